@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+	"time"
+
+	"tailscale.com/client/tailscale/v2"
+)
+
+func TestDeviceIncluded(t *testing.T) {
+	future := tailscale.Time{Time: time.Now().Add(24 * time.Hour)}
+	past := tailscale.Time{Time: time.Now().Add(-24 * time.Hour)}
+
+	for _, tc := range []struct {
+		name   string
+		cfg    *Config
+		device tailscale.Device
+		want   bool
+	}{
+		{
+			name:   "no filters includes everything",
+			cfg:    &Config{},
+			device: tailscale.Device{Authorized: false, Expires: past},
+			want:   true,
+		},
+		{
+			name:   "require authorized excludes unauthorized devices",
+			cfg:    &Config{RequireAuthorized: true},
+			device: tailscale.Device{Authorized: false},
+			want:   false,
+		},
+		{
+			name:   "require authorized includes authorized devices",
+			cfg:    &Config{RequireAuthorized: true},
+			device: tailscale.Device{Authorized: true},
+			want:   true,
+		},
+		{
+			name:   "exclude expired excludes devices past their expiry",
+			cfg:    &Config{ExcludeExpired: true},
+			device: tailscale.Device{Expires: past},
+			want:   false,
+		},
+		{
+			name:   "exclude expired includes devices with no expiry",
+			cfg:    &Config{ExcludeExpired: true},
+			device: tailscale.Device{},
+			want:   true,
+		},
+		{
+			name:   "exclude expired includes devices that haven't expired yet",
+			cfg:    &Config{ExcludeExpired: true},
+			device: tailscale.Device{Expires: future},
+			want:   true,
+		},
+		{
+			name:   "include tags excludes devices missing all of them",
+			cfg:    &Config{IncludeTags: stringSliceFlag{"tag:prod"}},
+			device: tailscale.Device{Tags: []string{"tag:dev"}},
+			want:   false,
+		},
+		{
+			name:   "include tags includes devices with any of them",
+			cfg:    &Config{IncludeTags: stringSliceFlag{"tag:prod", "tag:staging"}},
+			device: tailscale.Device{Tags: []string{"tag:staging"}},
+			want:   true,
+		},
+		{
+			name:   "exclude tags excludes devices with any of them",
+			cfg:    &Config{ExcludeTags: stringSliceFlag{"tag:staging"}},
+			device: tailscale.Device{Tags: []string{"tag:prod", "tag:staging"}},
+			want:   false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := deviceIncluded(tc.device, tc.cfg); got != tc.want {
+				t.Errorf("deviceIncluded() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tmpl    string
+		device  tailscale.Device
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "no template falls back to the short domain",
+			device: tailscale.Device{Name: "host.tailnet.ts.net"},
+			want:   "host",
+		},
+		{
+			name:    "no template and an invalid device name fails",
+			device:  tailscale.Device{Name: "not-a-ts-net-name"},
+			wantErr: true,
+		},
+		{
+			name:   "template renders from the device",
+			tmpl:   "{{.Hostname}}",
+			device: tailscale.Device{Hostname: "my-host"},
+			want:   "my-host",
+		},
+		{
+			name:   "template output is trimmed",
+			tmpl:   "  {{.Hostname}}  \n",
+			device: tailscale.Device{Hostname: "my-host"},
+			want:   "my-host",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var tmpl *template.Template
+			if tc.tmpl != "" {
+				tmpl = template.Must(template.New("name").Parse(tc.tmpl))
+			}
+
+			got, err := deviceName(tmpl, tc.device)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("deviceName() error = nil, want an error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("deviceName() error = %v", err)
+			}
+
+			if got != tc.want {
+				t.Errorf("deviceName() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// githubSyntaxDefault reports whether workflow-command annotations should be
+// emitted by default, mirroring the GITHUB_ACTIONS convention GitHub sets on
+// its own hosted and self-hosted runners.
+func githubSyntaxDefault() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+func ghError(file string, line, col int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "::error file=%s,line=%d,col=%d::%s\n", escapeProperty(file), line, col, escapeData(fmt.Sprintf(format, args...)))
+}
+
+func ghWarning(file string, line, col int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "::warning file=%s,line=%d,col=%d::%s\n", escapeProperty(file), line, col, escapeData(fmt.Sprintf(format, args...)))
+}
+
+func ghNotice(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "::notice::%s\n", escapeData(fmt.Sprintf(format, args...)))
+}
+
+// escapeData escapes a workflow-command's message (the part after ::) per
+// https://docs.github.com/en/actions/using-workflows/workflow-commands-for-github-actions#escaping-data.
+func escapeData(s string) string {
+	s = strings.ReplaceAll(s, "%", "%25")
+	s = strings.ReplaceAll(s, "\r", "%0D")
+	s = strings.ReplaceAll(s, "\n", "%0A")
+
+	return s
+}
+
+// escapeProperty escapes a workflow-command property value (e.g. file=...)
+// per the same doc as escapeData, which additionally requires escaping ':'
+// and ',' since those delimit properties.
+func escapeProperty(s string) string {
+	s = escapeData(s)
+	s = strings.ReplaceAll(s, ":", "%3A")
+	s = strings.ReplaceAll(s, ",", "%2C")
+
+	return s
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestEscapeData(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"plain message", "plain message"},
+		{"100% done", "100%25 done"},
+		{"line one\nline two", "line one%0Aline two"},
+		{"carriage\rreturn", "carriage%0Dreturn"},
+		{"a:b,c", "a:b,c"},
+	} {
+		if got := escapeData(tc.in); got != tc.want {
+			t.Errorf("escapeData(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestEscapeProperty(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"plain/file.go", "plain/file.go"},
+		{"path,with,commas", "path%2Cwith%2Ccommas"},
+		{"c:\\windows\\path", "c%3A\\windows\\path"},
+		{"100% done\n", "100%25 done%0A"},
+	} {
+		if got := escapeProperty(tc.in); got != tc.want {
+			t.Errorf("escapeProperty(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
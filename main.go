@@ -7,40 +7,125 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/tailscale/hujson"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"tailscale.com/client/tailscale/v2"
 )
 
+// tailscaleTokenURL is the OAuth token endpoint used to exchange a client
+// ID/secret pair for an access token.
+const tailscaleTokenURL = "https://api.tailscale.com/api/v2/oauth/token"
+
 var (
-	ErrInvalidDeviceName = errors.New("invalid device name: missing ts.net suffix")
-	ErrMissingPolicy     = errors.New("missing policy")
-	ErrNoCredentials     = errors.New("either api key or oauth credentials must be provided")
+	ErrInvalidDeviceName        = errors.New("invalid device name: missing ts.net suffix")
+	ErrMissingPolicy            = errors.New("missing policy")
+	ErrNoCredentials            = errors.New("either api key or oauth credentials must be provided")
+	ErrMissingSubcommand        = errors.New("missing subcommand")
+	ErrUnknownSubcommand        = errors.New("unknown subcommand")
+	ErrPolicyModifiedExternally = errors.New("policy modified externally in the admin console")
+)
+
+type Mode string
+
+const (
+	ModeGenerate Mode = "generate"
+	ModeTest     Mode = "test"
+	ModeApply    Mode = "apply"
 )
 
 type Config struct {
 	APIKey       string
 	ClientID     string
 	ClientSecret string
+	Mode         Mode
 	PolicyFile   string
+	CacheFile    string
+	GitHubSyntax bool
+
+	IncludeTags       stringSliceFlag
+	ExcludeTags       stringSliceFlag
+	RequireAuthorized bool
+	ExcludeExpired    bool
+	NameTemplate      string
+
+	OAuthToken     string
+	TokenCacheFile string
+
+	Replace bool
+	Prune   bool
+}
+
+// stringSliceFlag implements flag.Value, accumulating one value per
+// occurrence of a repeatable flag (e.g. -include-tag a -include-tag b).
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+
+	return nil
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "usage: ts-acl-hosts-gen [flags] policy.hujson\n")
+	fmt.Fprintf(os.Stderr, "usage: ts-acl-hosts-gen [flags] <generate|test|apply> policy.hujson\n")
 	flag.PrintDefaults()
 }
 
 func main() {
 	ctx := context.Background()
 
-	err := mainE(ctx)
+	cfg, err := parseFlags()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		usage()
 		os.Exit(1)
 	}
+
+	if err := run(ctx, cfg); err != nil {
+		reportError(cfg, err)
+		usage()
+		os.Exit(1)
+	}
+}
+
+// reportError prints err to stderr, additionally emitting a GitHub Actions
+// error annotation when cfg.GitHubSyntax is set so the failure shows up
+// inline on the PR diff rather than only in the raw log.
+func reportError(cfg *Config, err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+
+	if !cfg.GitHubSyntax {
+		return
+	}
+
+	line, col := 1, 1
+
+	var perr *PolicyParseError
+	if errors.As(err, &perr) {
+		line, col = perr.Line, perr.Col
+	}
+
+	ghError(cfg.PolicyFile, line, col, "%s", err)
+}
+
+// reportWarning is reportError's non-fatal counterpart, used for problems
+// (like a single bad device name) that don't stop the run.
+func reportWarning(cfg *Config, err error) {
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+
+	if cfg.GitHubSyntax {
+		ghWarning(cfg.PolicyFile, 1, 1, "%s", err)
+	}
 }
 
 func parseFlags() (*Config, error) {
@@ -49,6 +134,16 @@ func parseFlags() (*Config, error) {
 	flag.StringVar(&cfg.APIKey, "api-key", "", "Tailscale API key")
 	flag.StringVar(&cfg.ClientID, "oauth-id", "", "Tailscale OAuth client ID")
 	flag.StringVar(&cfg.ClientSecret, "oauth-secret", "", "Tailscale OAuth client secret")
+	flag.StringVar(&cfg.CacheFile, "cache-file", "", "path to cache the last-applied policy ETag")
+	flag.BoolVar(&cfg.GitHubSyntax, "github-syntax", githubSyntaxDefault(), "emit GitHub Actions workflow-command annotations")
+	flag.Var(&cfg.IncludeTags, "include-tag", "only include devices tagged with this tag (repeatable)")
+	flag.Var(&cfg.ExcludeTags, "exclude-tag", "exclude devices tagged with this tag (repeatable)")
+	flag.BoolVar(&cfg.RequireAuthorized, "require-authorized", false, "only include devices that are authorized")
+	flag.BoolVar(&cfg.ExcludeExpired, "exclude-expired", false, "exclude devices whose node key has expired")
+	flag.StringVar(&cfg.NameTemplate, "name-template", "", "text/template for deriving a host name from a device (defaults to the ts.net short name)")
+	flag.StringVar(&cfg.TokenCacheFile, "token-cache", "", "path to cache the OAuth access token (mode 0600)")
+	flag.BoolVar(&cfg.Replace, "replace", false, "replace the entire /hosts object instead of merging into it")
+	flag.BoolVar(&cfg.Prune, "prune", false, "remove managed host entries whose devices are no longer present (merge mode only)")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -65,27 +160,55 @@ func parseFlags() (*Config, error) {
 		cfg.ClientSecret = os.Getenv("TS_OAUTH_SECRET")
 	}
 
+	if cfg.OAuthToken == "" {
+		cfg.OAuthToken = os.Getenv("TS_OAUTH_TOKEN")
+	}
+
 	args := flag.Args()
-	if len(args) != 1 {
+	if len(args) < 1 {
+		return nil, ErrMissingSubcommand
+	}
+
+	switch Mode(args[0]) {
+	case ModeGenerate, ModeTest, ModeApply:
+		cfg.Mode = Mode(args[0])
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSubcommand, args[0])
+	}
+
+	if len(args) != 2 {
 		return nil, ErrMissingPolicy
 	}
 
-	cfg.PolicyFile = args[0]
+	cfg.PolicyFile = args[1]
 
 	return cfg, nil
 }
 
-func createTailscaleClient(cfg *Config) (*tailscale.Client, error) {
+func createTailscaleClient(ctx context.Context, cfg *Config) (*tailscale.Client, error) {
 	switch {
+	case cfg.OAuthToken != "":
+		client := &tailscale.Client{
+			Tailnet: "-",
+			HTTP:    oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: cfg.OAuthToken})),
+		}
+		return client, nil
 	case cfg.ClientID != "" && cfg.ClientSecret != "":
-		oauthScopes := []string{"devices:core:read"}
+		ccConfig := clientcredentials.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			TokenURL:     tailscaleTokenURL,
+			Scopes:       []string{"devices:core:read", "acl:write"},
+		}
+
+		tokenSource := ccConfig.TokenSource(ctx)
+		if cfg.TokenCacheFile != "" {
+			tokenSource = newCachingTokenSource(cfg.TokenCacheFile, tokenSource)
+		}
+
 		client := &tailscale.Client{
 			Tailnet: "-",
-			HTTP: tailscale.OAuthConfig{
-				ClientID:     cfg.ClientID,
-				ClientSecret: cfg.ClientSecret,
-				Scopes:       oauthScopes,
-			}.HTTPClient(),
+			HTTP:    oauth2.NewClient(ctx, tokenSource),
 		}
 		return client, nil
 	case cfg.APIKey != "":
@@ -99,47 +222,128 @@ func createTailscaleClient(cfg *Config) (*tailscale.Client, error) {
 	}
 }
 
-func mainE(ctx context.Context) error {
-	cfg, err := parseFlags()
+func run(ctx context.Context, cfg *Config) error {
+	client, err := createTailscaleClient(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	client, err := createTailscaleClient(cfg)
-	if err != nil {
-		return err
+	var nameTmpl *template.Template
+	if cfg.NameTemplate != "" {
+		nameTmpl, err = template.New("name").Parse(cfg.NameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid name template: %w", err)
+		}
 	}
 
 	fmt.Fprintln(os.Stderr, "Fetching hosts...")
 
-	hosts, err := fetchHosts(ctx, client)
+	hosts, warnings, err := fetchHosts(ctx, client, cfg, nameTmpl)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintln(os.Stderr, "Formatting policy...")
+	for _, w := range warnings {
+		reportWarning(cfg, w)
+	}
+
+	switch cfg.Mode {
+	case ModeGenerate:
+		fmt.Fprintln(os.Stderr, "Formatting policy...")
 
-	return patchPolicy(cfg.PolicyFile, hosts)
+		return patchPolicy(cfg, hosts)
+	case ModeTest:
+		return testPolicy(ctx, client, cfg, hosts)
+	case ModeApply:
+		return applyPolicy(ctx, client, cfg, hosts)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownSubcommand, cfg.Mode)
+	}
 }
 
-func fetchHosts(ctx context.Context, client *tailscale.Client) (map[string]string, error) {
+// fetchHosts lists the tailnet's devices, filters them per cfg, and derives
+// each host's name via nameTmpl (or deviceShortDomain if nil). A device that
+// fails filtering silently drops out; a device that fails naming produces a
+// warning (rather than failing outright) so one bad device doesn't block
+// every other host from syncing.
+func fetchHosts(ctx context.Context, client *tailscale.Client, cfg *Config, nameTmpl *template.Template) (map[string]string, []error, error) {
 	devices, err := client.Devices().List(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch Tailscale devices: %w", err)
+		return nil, nil, fmt.Errorf("failed to fetch Tailscale devices: %w", err)
 	}
 
 	hosts := map[string]string{}
+	var warnings []error
 
 	for _, device := range devices {
-		name, err := deviceShortDomain(device)
+		if !deviceIncluded(device, cfg) {
+			continue
+		}
+
+		if len(device.Addresses) == 0 {
+			warnings = append(warnings, fmt.Errorf("bad host: device %q has no addresses", device.Name))
+			continue
+		}
+
+		name, err := deviceName(nameTmpl, device)
 		if err != nil {
-			return nil, fmt.Errorf("bad host: %w", err)
+			warnings = append(warnings, fmt.Errorf("bad host: %w", err))
+			continue
 		}
 
 		hosts[name] = device.Addresses[0]
 	}
 
-	return hosts, nil
+	return hosts, warnings, nil
+}
+
+// deviceIncluded reports whether device passes the tag, authorization, and
+// expiry filters configured on cfg.
+func deviceIncluded(device tailscale.Device, cfg *Config) bool {
+	if cfg.RequireAuthorized && !device.Authorized {
+		return false
+	}
+
+	if cfg.ExcludeExpired && !device.Expires.IsZero() && device.Expires.Before(time.Now()) {
+		return false
+	}
+
+	if len(cfg.IncludeTags) > 0 && !deviceHasAnyTag(device, cfg.IncludeTags) {
+		return false
+	}
+
+	if len(cfg.ExcludeTags) > 0 && deviceHasAnyTag(device, cfg.ExcludeTags) {
+		return false
+	}
+
+	return true
+}
+
+func deviceHasAnyTag(device tailscale.Device, tags []string) bool {
+	for _, have := range device.Tags {
+		for _, want := range tags {
+			if have == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// deviceName derives a host's short name from device, executing tmpl with
+// the device as data if given, or falling back to deviceShortDomain.
+func deviceName(tmpl *template.Template, device tailscale.Device) (string, error) {
+	if tmpl == nil {
+		return deviceShortDomain(device)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, device); err != nil {
+		return "", fmt.Errorf("failed to render name template: %w", err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
 }
 
 type JSONPatchOperation struct {
@@ -160,7 +364,7 @@ func openPolicy(filename string) (*os.File, os.FileInfo, error) {
 			return nil, nil, fmt.Errorf("failed to create policy file: %w", err)
 		}
 
-		if _, err := f.WriteString("{\n}"); err != nil {
+		if _, err := f.WriteString(emptyPolicy); err != nil {
 			f.Close()
 
 			return nil, nil, fmt.Errorf("failed to write initial JSON: %w", err)
@@ -182,53 +386,251 @@ func openPolicy(filename string) (*os.File, os.FileInfo, error) {
 	return policyFile, info, nil
 }
 
-func patchPolicy(filename string, hosts map[string]string) error {
-	policyFile, info, err := openPolicy(filename)
+// emptyPolicy is the document readPolicy returns for a policy file that
+// doesn't exist yet, matching the content openPolicy would create on disk.
+const emptyPolicy = "{\n}"
+
+// readPolicy reads filename if it exists, and otherwise returns emptyPolicy,
+// without ever creating or modifying anything on disk. Use this for
+// read-only operations like test; use openPolicy when the caller intends to
+// write the file back, such as generate's local-write path.
+func readPolicy(filename string) ([]byte, error) {
+	src, err := os.ReadFile(filename)
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return []byte(emptyPolicy), nil
+		}
+
+		return nil, fmt.Errorf("failed read policy: %w", err)
 	}
-	defer policyFile.Close()
 
-	src, err := io.ReadAll(policyFile)
-	if err != nil {
-		return fmt.Errorf("failed read policy: %w", err)
+	return src, nil
+}
+
+// PolicyParseError wraps a HuJSON parse failure with the line and column it
+// occurred at, so diagnostics can point at the exact offset in the file.
+type PolicyParseError struct {
+	err       error
+	Line, Col int
+}
+
+func (e *PolicyParseError) Error() string { return e.err.Error() }
+func (e *PolicyParseError) Unwrap() error { return e.err }
+
+// hujsonLineColPattern extracts the line and column hujson.Parse reports in
+// its error text. The library doesn't expose a structured offset in this
+// version (github.com/tailscale/hujson v0.0.0-20250605163823-992244df8c5a),
+// only a prefix of the form "hujson: line %d, column %d: ...", so that's
+// what we parse.
+var hujsonLineColPattern = regexp.MustCompile(`line (\d+), column (\d+)`)
+
+// lineCol extracts the line and column a hujson.Parse error occurred at, or
+// 1,1 if err doesn't match the expected format.
+func lineCol(err error) (line, col int) {
+	line, col = 1, 1
+
+	m := hujsonLineColPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return line, col
 	}
 
+	if n, err := strconv.Atoi(m[1]); err == nil {
+		line = n
+	}
+
+	if n, err := strconv.Atoi(m[2]); err == nil {
+		col = n
+	}
+
+	return line, col
+}
+
+// existingHosts best-effort reads the /hosts object already present in src,
+// for use diffing against the freshly fetched hosts. A malformed or absent
+// document simply yields no existing hosts rather than an error.
+func existingHosts(src []byte) map[string]string {
 	input := make([]byte, len(src))
 	copy(input, src)
 
 	value, err := hujson.Parse(input)
 	if err != nil {
-		return fmt.Errorf("failed parse policy: %w", err)
+		return nil
+	}
+
+	value.Standardize()
+
+	var doc struct {
+		Hosts map[string]string `json:"hosts"`
+	}
+	if err := json.Unmarshal([]byte(value.String()), &doc); err != nil {
+		return nil
+	}
+
+	return doc.Hosts
+}
+
+func diffHosts(oldHosts, newHosts map[string]string) (added, removed, changed int) {
+	for name, addr := range newHosts {
+		oldAddr, ok := oldHosts[name]
+		switch {
+		case !ok:
+			added++
+		case oldAddr != addr:
+			changed++
+		}
+	}
+
+	for name := range oldHosts {
+		if _, ok := newHosts[name]; !ok {
+			removed++
+		}
 	}
 
-	var operation string
-	if value.Find("hosts") == nil {
-		operation = "add"
+	return added, removed, changed
+}
+
+// emitHostsSummary reports how the hosts about to be written differ from
+// what's already in the policy, as a GitHub Actions notice when available so
+// reviewers can see the effective diff without opening the generated file.
+func emitHostsSummary(cfg *Config, oldHosts, newHosts map[string]string) {
+	added, removed, changed := diffHosts(oldHosts, newHosts)
+	msg := fmt.Sprintf("hosts: %d added, %d removed, %d changed", added, removed, changed)
+
+	if cfg.GitHubSyntax {
+		ghNotice("%s", msg)
 	} else {
-		operation = "replace"
+		fmt.Fprintln(os.Stderr, msg)
 	}
+}
+
+// patchHostsHuJSON applies hosts to the policy's /hosts object, preserving
+// comments in the rest of the document; the whole result is then passed
+// through hujson's Format, so whitespace and layout are normalized. In the
+// default merge mode, only entries this tool previously marked as managed
+// (see markManagedHosts) are candidates for update or removal; any other
+// hand-authored /hosts entries, and any comments on them, are kept by
+// patching each changed name at its own /hosts/<name> path rather than
+// replacing the whole object. With cfg.Replace, the whole /hosts object is
+// overwritten instead. It also returns the resulting /hosts map, for
+// callers that want to report what changed.
+func patchHostsHuJSON(cfg *Config, src []byte, hosts map[string]string) ([]byte, map[string]string, error) {
+	input := make([]byte, len(src))
+	copy(input, src)
+
+	value, err := hujson.Parse(input)
+	if err != nil {
+		line, col := lineCol(err)
 
-	patchOp := JSONPatchOperation{
-		Operation: operation,
-		Path:      "/hosts",
-		Value:     hosts,
+		return nil, nil, &PolicyParseError{err: fmt.Errorf("failed parse policy: %w", err), Line: line, Col: col}
 	}
-	patch := []JSONPatchOperation{patchOp}
 
-	patchjson, err := json.Marshal(patch)
+	finalHosts, managed, ops := mergeHosts(cfg, value.Find("/hosts") != nil, src, hosts)
+
+	patchjson, err := json.Marshal(ops)
 	if err != nil {
-		return fmt.Errorf("failed to update policy: %w", err)
+		return nil, nil, fmt.Errorf("failed to update policy: %w", err)
 	}
 
 	err = value.Patch(patchjson)
 	if err != nil {
-		return fmt.Errorf("failed to update policy: %w", err)
+		return nil, nil, fmt.Errorf("failed to update policy: %w", err)
 	}
 
 	value.Format()
 
-	err = os.WriteFile(filename, []byte(value.String()), info.Mode().Perm())
+	out := markManagedHosts([]byte(value.String()), managed)
+
+	return out, finalHosts, nil
+}
+
+// mergeHosts computes the final /hosts map, the set of names within it that
+// should be (re)marked as managed, and the minimal set of JSON Patch
+// operations needed to turn the current /hosts object into that map. Each
+// changed name gets its own /hosts/<name> add/replace/remove operation so
+// that untouched entries, and any comments on them, are left exactly as
+// they were.
+func mergeHosts(cfg *Config, hostsObjectExists bool, src []byte, hosts map[string]string) (final map[string]string, managed map[string]bool, ops []JSONPatchOperation) {
+	if cfg.Replace || !hostsObjectExists {
+		final = map[string]string{}
+		managed = map[string]bool{}
+
+		for name, addr := range hosts {
+			final[name] = addr
+			managed[name] = true
+		}
+
+		operation := "add"
+		if hostsObjectExists {
+			operation = "replace"
+		}
+
+		return final, managed, []JSONPatchOperation{{Operation: operation, Path: "/hosts", Value: final}}
+	}
+
+	existing := existingHosts(src)
+	final = map[string]string{}
+	for name, addr := range existing {
+		final[name] = addr
+	}
+
+	managed = map[string]bool{}
+	for name := range managedHostNames(src) {
+		managed[name] = true
+	}
+
+	for name, addr := range hosts {
+		if existingAddr, ok := final[name]; !ok {
+			ops = append(ops, JSONPatchOperation{Operation: "add", Path: "/hosts/" + jsonPointerEscape(name), Value: addr})
+		} else if existingAddr != addr {
+			ops = append(ops, JSONPatchOperation{Operation: "replace", Path: "/hosts/" + jsonPointerEscape(name), Value: addr})
+		}
+
+		final[name] = addr
+		managed[name] = true
+	}
+
+	if cfg.Prune {
+		for name := range managed {
+			if _, ok := hosts[name]; !ok {
+				ops = append(ops, JSONPatchOperation{Operation: "remove", Path: "/hosts/" + jsonPointerEscape(name)})
+				delete(final, name)
+				delete(managed, name)
+			}
+		}
+	}
+
+	return final, managed, ops
+}
+
+// jsonPointerEscape escapes a JSON object member name for use as a segment
+// of a JSON Pointer (RFC 6901).
+func jsonPointerEscape(name string) string {
+	name = strings.ReplaceAll(name, "~", "~0")
+	name = strings.ReplaceAll(name, "/", "~1")
+
+	return name
+}
+
+func patchPolicy(cfg *Config, hosts map[string]string) error {
+	policyFile, info, err := openPolicy(cfg.PolicyFile)
+	if err != nil {
+		return err
+	}
+	defer policyFile.Close()
+
+	src, err := io.ReadAll(policyFile)
+	if err != nil {
+		return fmt.Errorf("failed read policy: %w", err)
+	}
+
+	out, finalHosts, err := patchHostsHuJSON(cfg, src, hosts)
+	if err != nil {
+		return err
+	}
+
+	emitHostsSummary(cfg, existingHosts(src), finalHosts)
+
+	err = os.WriteFile(cfg.PolicyFile, out, info.Mode().Perm())
 	if err != nil {
 		return fmt.Errorf("failed to write policy: %w", err)
 	}
@@ -236,6 +638,105 @@ func patchPolicy(filename string, hosts map[string]string) error {
 	return nil
 }
 
+// testPolicy patches the local policy file with hosts in memory and asks the
+// Tailscale API to validate the result, without persisting anything locally
+// or remotely.
+func testPolicy(ctx context.Context, client *tailscale.Client, cfg *Config, hosts map[string]string) error {
+	src, err := readPolicy(cfg.PolicyFile)
+	if err != nil {
+		return err
+	}
+
+	out, finalHosts, err := patchHostsHuJSON(cfg, src, hosts)
+	if err != nil {
+		return err
+	}
+
+	emitHostsSummary(cfg, existingHosts(src), finalHosts)
+
+	fmt.Fprintln(os.Stderr, "Validating policy...")
+
+	if err := client.PolicyFile().Validate(ctx, string(out)); err != nil {
+		return fmt.Errorf("policy failed validation: %w", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Policy is valid.")
+
+	return nil
+}
+
+// applyPolicy fetches the tailnet's current policy and ETag, patches in the
+// hosts map, and pushes the result back with an If-Match precondition so
+// concurrent edits in the admin console are never silently clobbered.
+func applyPolicy(ctx context.Context, client *tailscale.Client, cfg *Config, hosts map[string]string) error {
+	fmt.Fprintln(os.Stderr, "Fetching current policy...")
+
+	rawACL, err := client.PolicyFile().Raw(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch current policy: %w", err)
+	}
+
+	if cfg.CacheFile != "" {
+		if err := checkCachedETag(cfg.CacheFile, rawACL.ETag); err != nil {
+			return err
+		}
+	}
+
+	out, finalHosts, err := patchHostsHuJSON(cfg, []byte(rawACL.HuJSON), hosts)
+	if err != nil {
+		return err
+	}
+
+	emitHostsSummary(cfg, existingHosts([]byte(rawACL.HuJSON)), finalHosts)
+
+	fmt.Fprintln(os.Stderr, "Applying policy...")
+
+	if err := client.PolicyFile().Set(ctx, string(out), rawACL.ETag); err != nil {
+		var apiErr tailscale.APIError
+		if errors.As(err, &apiErr) && strings.Contains(apiErr.Error(), fmt.Sprintf("(%d)", http.StatusPreconditionFailed)) {
+			return fmt.Errorf("%w: %v", ErrPolicyModifiedExternally, apiErr)
+		}
+
+		return fmt.Errorf("failed to apply policy: %w", err)
+	}
+
+	if cfg.CacheFile == "" {
+		return nil
+	}
+
+	newRawACL, err := client.PolicyFile().Raw(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refetch policy after apply: %w", err)
+	}
+
+	if err := os.WriteFile(cfg.CacheFile, []byte(newRawACL.ETag), 0o600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+
+	return nil
+}
+
+// checkCachedETag compares the ETag cached from the last successful apply
+// against the tailnet's current ETag, returning ErrPolicyModifiedExternally
+// if they differ so out-of-band edits are caught before we even attempt a
+// patch, rather than only on the server's If-Match response.
+func checkCachedETag(cacheFile, currentETag string) error {
+	cached, err := os.ReadFile(cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	if string(cached) != currentETag {
+		return fmt.Errorf("%w: cached ETag %q does not match current ETag %q", ErrPolicyModifiedExternally, cached, currentETag)
+	}
+
+	return nil
+}
+
 func deviceShortDomain(device tailscale.Device) (string, error) {
 	parts := strings.Split(device.Name, ".")
 	if len(parts) < 3 {
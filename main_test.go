@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCachedETag(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		cached     string
+		skipWrite  bool
+		currentTag string
+		wantErr    bool
+	}{
+		{
+			name:      "no cache file is not an error",
+			skipWrite: true,
+			wantErr:   false,
+		},
+		{
+			name:       "matching ETag is not an error",
+			cached:     `"abc123"`,
+			currentTag: `"abc123"`,
+			wantErr:    false,
+		},
+		{
+			name:       "mismatched ETag reports the policy as modified externally",
+			cached:     `"abc123"`,
+			currentTag: `"def456"`,
+			wantErr:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cacheFile := filepath.Join(t.TempDir(), "etag")
+
+			if !tc.skipWrite {
+				if err := os.WriteFile(cacheFile, []byte(tc.cached), 0o600); err != nil {
+					t.Fatalf("failed to seed cache file: %v", err)
+				}
+			}
+
+			err := checkCachedETag(cacheFile, tc.currentTag)
+			if tc.wantErr && !errors.Is(err, ErrPolicyModifiedExternally) {
+				t.Errorf("checkCachedETag() error = %v, want ErrPolicyModifiedExternally", err)
+			}
+
+			if !tc.wantErr && err != nil {
+				t.Errorf("checkCachedETag() error = %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestPatchHostsHuJSONReportsTheActualParseErrorLocation(t *testing.T) {
+	src := []byte("{\n\t\"hosts\": {\n\t\t\"a\": ,\n\t},\n}\n")
+
+	_, _, err := patchHostsHuJSON(&Config{}, src, map[string]string{})
+
+	var perr *PolicyParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("patchHostsHuJSON() error = %v, want *PolicyParseError", err)
+	}
+
+	if perr.Line != 3 || perr.Col != 8 {
+		t.Errorf("PolicyParseError{Line: %d, Col: %d}, want {Line: 3, Col: 8}", perr.Line, perr.Col)
+	}
+}
+
+func TestReadPolicyMissingFileReturnsEmptyDocumentWithoutCreatingIt(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.hujson")
+
+	src, err := readPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("readPolicy() error = %v", err)
+	}
+
+	if string(src) != emptyPolicy {
+		t.Errorf("readPolicy() = %q, want %q", src, emptyPolicy)
+	}
+
+	if _, err := os.Stat(policyFile); !os.IsNotExist(err) {
+		t.Errorf("readPolicy() created %s on disk, want no file", policyFile)
+	}
+}
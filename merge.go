@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// managedMarker is the comment text written above a /hosts entry that this
+// tool owns. Entries without it are assumed hand-authored and are left
+// alone by merge mode.
+const managedMarker = "ts-acl-hosts-gen:managed"
+
+var hostKeyPattern = regexp.MustCompile(`^(\s*)"([^"]*)"\s*:`)
+
+// hostsObjectSpan returns the [start, end) byte range of the /hosts object
+// within src, so marker scanning and insertion can be scoped to it and
+// never mistake an unrelated top-level or nested key (e.g. "groups",
+// "ssh", or a tagOwners child) for a managed host. It returns ok=false if
+// src doesn't parse or has no /hosts object.
+func hostsObjectSpan(src []byte) (start, end int, ok bool) {
+	value, err := hujson.Parse(src)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	hosts := value.Find("/hosts")
+	if hosts == nil {
+		return 0, 0, false
+	}
+
+	return hosts.StartOffset, hosts.EndOffset, true
+}
+
+// managedHostNames scans the /hosts object in src for entries immediately
+// preceded by the managedMarker comment, i.e. entries this tool wrote on a
+// previous run and is therefore free to update or prune. Matches outside
+// the /hosts object are ignored, so a host name that collides with an
+// unrelated ACL key (e.g. "groups") is never mistaken for a managed host.
+func managedHostNames(src []byte) map[string]bool {
+	start, end, ok := hostsObjectSpan(src)
+	if !ok {
+		return map[string]bool{}
+	}
+
+	return scanManagedHostNames(src[start:end])
+}
+
+func scanManagedHostNames(src []byte) map[string]bool {
+	managed := map[string]bool{}
+	pendingMarker := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "//") && strings.Contains(trimmed, managedMarker):
+			pendingMarker = true
+		case pendingMarker:
+			if m := hostKeyPattern.FindStringSubmatch(line); m != nil {
+				managed[m[2]] = true
+			}
+
+			pendingMarker = false
+		default:
+			pendingMarker = false
+		}
+	}
+
+	return managed
+}
+
+// markManagedHosts inserts the managedMarker comment above every entry
+// named in managed that doesn't already carry one, so future runs know
+// they're free to update or prune it. The scan is scoped to the /hosts
+// object in out, so a host name that collides with an unrelated ACL key
+// never gets marked by mistake.
+func markManagedHosts(out []byte, managed map[string]bool) []byte {
+	if len(managed) == 0 {
+		return out
+	}
+
+	start, end, ok := hostsObjectSpan(out)
+	if !ok {
+		return out
+	}
+
+	marked := insertManagedMarkers(out[start:end], managed)
+
+	result := make([]byte, 0, len(out)+len(marked)-(end-start))
+	result = append(result, out[:start]...)
+	result = append(result, marked...)
+	result = append(result, out[end:]...)
+
+	return result
+}
+
+func insertManagedMarkers(src []byte, managed map[string]bool) []byte {
+	lines := strings.Split(string(src), "\n")
+	result := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		if m := hostKeyPattern.FindStringSubmatch(line); m != nil && managed[m[2]] {
+			prev := ""
+			if len(result) > 0 {
+				prev = strings.TrimSpace(result[len(result)-1])
+			}
+
+			if !strings.Contains(prev, managedMarker) {
+				result = append(result, m[1]+"// "+managedMarker)
+			}
+		}
+
+		result = append(result, line)
+	}
+
+	return []byte(strings.Join(result, "\n"))
+}
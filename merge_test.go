@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeHosts(t *testing.T) {
+	src := []byte(`{
+	"hosts": {
+		// do not touch
+		"pinned": "10.0.0.1",
+
+		// ts-acl-hosts-gen:managed
+		"stale": "10.0.0.2",
+	},
+}
+`)
+
+	for _, tc := range []struct {
+		name       string
+		cfg        *Config
+		hosts      map[string]string
+		wantFinal  map[string]string
+		wantOps    int
+		wantManage []string
+	}{
+		{
+			name:  "merge adds and updates managed entries, leaves hand-authored alone",
+			cfg:   &Config{},
+			hosts: map[string]string{"stale": "10.0.0.99", "new": "10.0.0.3"},
+			wantFinal: map[string]string{
+				"pinned": "10.0.0.1",
+				"stale":  "10.0.0.99",
+				"new":    "10.0.0.3",
+			},
+			wantOps:    2,
+			wantManage: []string{"stale", "new"},
+		},
+		{
+			name:  "prune removes managed entries missing from hosts",
+			cfg:   &Config{Prune: true},
+			hosts: map[string]string{"new": "10.0.0.3"},
+			wantFinal: map[string]string{
+				"pinned": "10.0.0.1",
+				"new":    "10.0.0.3",
+			},
+			wantOps:    2,
+			wantManage: []string{"new"},
+		},
+		{
+			name:  "replace overwrites the whole object",
+			cfg:   &Config{Replace: true},
+			hosts: map[string]string{"new": "10.0.0.3"},
+			wantFinal: map[string]string{
+				"new": "10.0.0.3",
+			},
+			wantOps:    1,
+			wantManage: []string{"new"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			final, managed, ops := mergeHosts(tc.cfg, true, src, tc.hosts)
+
+			if len(final) != len(tc.wantFinal) {
+				t.Fatalf("final = %v, want %v", final, tc.wantFinal)
+			}
+
+			for name, addr := range tc.wantFinal {
+				if final[name] != addr {
+					t.Errorf("final[%q] = %q, want %q", name, final[name], addr)
+				}
+			}
+
+			if len(ops) != tc.wantOps && tc.cfg.Replace == false {
+				t.Errorf("len(ops) = %d, want %d", len(ops), tc.wantOps)
+			}
+
+			for _, name := range tc.wantManage {
+				if !managed[name] {
+					t.Errorf("managed[%q] = false, want true", name)
+				}
+			}
+
+			if tc.cfg.Replace == false && managed["pinned"] {
+				t.Errorf("managed[\"pinned\"] = true, want false (hand-authored entry untouched)")
+			}
+		})
+	}
+}
+
+func TestPatchHostsHuJSONPreservesUntouchedComments(t *testing.T) {
+	src := []byte(`{
+	"hosts": {
+		// do not touch
+		"pinned": "10.0.0.1",
+
+		// ts-acl-hosts-gen:managed
+		"stale": "10.0.0.2",
+	},
+}
+`)
+
+	cfg := &Config{Prune: true}
+	hosts := map[string]string{"fresh": "10.0.0.3"}
+
+	out, final, err := patchHostsHuJSON(cfg, src, hosts)
+	if err != nil {
+		t.Fatalf("patchHostsHuJSON() error = %v", err)
+	}
+
+	if final["pinned"] != "10.0.0.1" {
+		t.Errorf("final[\"pinned\"] = %q, want %q", final["pinned"], "10.0.0.1")
+	}
+
+	if _, ok := final["stale"]; ok {
+		t.Errorf("final still has pruned entry %q", "stale")
+	}
+
+	if !strings.Contains(string(out), "// do not touch") {
+		t.Errorf("output lost the comment on the untouched entry:\n%s", out)
+	}
+}
+
+func TestManagedHostNamesScopedToHostsObject(t *testing.T) {
+	src := []byte(`{
+	"groups": {
+		// ts-acl-hosts-gen:managed
+		"group:eng": ["alice@example.com"],
+	},
+	"hosts": {
+		// ts-acl-hosts-gen:managed
+		"web": "10.0.0.1",
+	},
+}
+`)
+
+	managed := managedHostNames(src)
+
+	if !managed["web"] {
+		t.Errorf("managed[\"web\"] = false, want true")
+	}
+
+	if managed["group:eng"] {
+		t.Errorf("managed[\"group:eng\"] = true, want false (marker is outside /hosts)")
+	}
+}
+
+func TestMarkManagedHostsScopedToHostsObject(t *testing.T) {
+	src := []byte(`{
+	"groups": {
+		"hosts": ["alice@example.com"],
+	},
+	"hosts": {
+		"web": "10.0.0.1",
+	},
+}
+`)
+
+	out := string(markManagedHosts(src, map[string]bool{"hosts": true, "web": true}))
+
+	lines := strings.Split(out, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, `"hosts": ["alice@example.com"]`) {
+			if i > 0 && strings.Contains(lines[i-1], managedMarker) {
+				t.Errorf("marker inserted above unrelated \"hosts\" key inside groups:\n%s", out)
+			}
+		}
+
+		if strings.Contains(line, `"web": "10.0.0.1"`) {
+			if i == 0 || !strings.Contains(lines[i-1], managedMarker) {
+				t.Errorf("no marker inserted above managed /hosts entry \"web\":\n%s", out)
+			}
+		}
+	}
+}
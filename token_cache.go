@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenExpiryMargin is how far ahead of a cached token's actual expiry we
+// consider it stale, so a refresh has time to happen before a request
+// actually hits an expired token.
+const tokenExpiryMargin = 5 * time.Minute
+
+// cachingTokenSource wraps an oauth2.TokenSource, persisting the minted
+// token to a file (mode 0600) so that repeated short-lived invocations of
+// this tool, such as back-to-back CI runs, don't each pay for a fresh
+// client-credential exchange.
+type cachingTokenSource struct {
+	path string
+	src  oauth2.TokenSource
+}
+
+func newCachingTokenSource(path string, src oauth2.TokenSource) *cachingTokenSource {
+	return &cachingTokenSource{path: path, src: src}
+}
+
+type cachedToken struct {
+	AccessToken string    `json:"access_token"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+func (c *cachingTokenSource) Token() (*oauth2.Token, error) {
+	if tok, ok := c.readCached(); ok {
+		return tok, nil
+	}
+
+	tok, err := c.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.writeCached(tok); err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+func (c *cachingTokenSource) readCached() (*oauth2.Token, bool) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, false
+	}
+
+	var cached cachedToken
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false
+	}
+
+	if cached.AccessToken == "" || !cached.Expiry.After(time.Now().Add(tokenExpiryMargin)) {
+		return nil, false
+	}
+
+	return &oauth2.Token{AccessToken: cached.AccessToken, Expiry: cached.Expiry}, true
+}
+
+func (c *cachingTokenSource) writeCached(tok *oauth2.Token) error {
+	data, err := json.Marshal(cachedToken{AccessToken: tok.AccessToken, Expiry: tok.Expiry})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, data, 0o600)
+}
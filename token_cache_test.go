@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeTokenSource counts how many times Token is called, so tests can
+// assert whether the cache was actually consulted.
+type fakeTokenSource struct {
+	calls int
+	tok   *oauth2.Token
+	err   error
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return f.tok, nil
+}
+
+func TestCachingTokenSourceCacheMiss(t *testing.T) {
+	src := &fakeTokenSource{tok: &oauth2.Token{AccessToken: "fresh", Expiry: time.Now().Add(time.Hour)}}
+	c := newCachingTokenSource(filepath.Join(t.TempDir(), "token.json"), src)
+
+	tok, err := c.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	if tok.AccessToken != "fresh" {
+		t.Errorf("Token().AccessToken = %q, want %q", tok.AccessToken, "fresh")
+	}
+
+	if src.calls != 1 {
+		t.Errorf("underlying source called %d times, want 1", src.calls)
+	}
+}
+
+func TestCachingTokenSourceCacheHit(t *testing.T) {
+	src := &fakeTokenSource{tok: &oauth2.Token{AccessToken: "should-not-be-used", Expiry: time.Now().Add(time.Hour)}}
+	c := newCachingTokenSource(filepath.Join(t.TempDir(), "token.json"), src)
+
+	if _, err := c.Token(); err != nil {
+		t.Fatalf("first Token() error = %v", err)
+	}
+
+	tok, err := c.Token()
+	if err != nil {
+		t.Fatalf("second Token() error = %v", err)
+	}
+
+	if tok.AccessToken != "should-not-be-used" {
+		t.Errorf("Token().AccessToken = %q, want %q", tok.AccessToken, "should-not-be-used")
+	}
+
+	if src.calls != 1 {
+		t.Errorf("underlying source called %d times, want 1 (second call should hit the cache)", src.calls)
+	}
+}
+
+func TestCachingTokenSourceRefreshesNearExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+
+	first := &fakeTokenSource{tok: &oauth2.Token{AccessToken: "about-to-expire", Expiry: time.Now().Add(tokenExpiryMargin - time.Second)}}
+	c := newCachingTokenSource(path, first)
+
+	if _, err := c.Token(); err != nil {
+		t.Fatalf("first Token() error = %v", err)
+	}
+
+	second := &fakeTokenSource{tok: &oauth2.Token{AccessToken: "refreshed", Expiry: time.Now().Add(time.Hour)}}
+	c.src = second
+
+	tok, err := c.Token()
+	if err != nil {
+		t.Fatalf("second Token() error = %v", err)
+	}
+
+	if tok.AccessToken != "refreshed" {
+		t.Errorf("Token().AccessToken = %q, want %q (cached token within the expiry margin should not be reused)", tok.AccessToken, "refreshed")
+	}
+
+	if second.calls != 1 {
+		t.Errorf("underlying source called %d times, want 1", second.calls)
+	}
+}
+
+func TestCachingTokenSourcePropagatesUnderlyingError(t *testing.T) {
+	wantErr := errors.New("oauth exchange failed")
+	src := &fakeTokenSource{err: wantErr}
+	c := newCachingTokenSource(filepath.Join(t.TempDir(), "token.json"), src)
+
+	if _, err := c.Token(); !errors.Is(err, wantErr) {
+		t.Errorf("Token() error = %v, want %v", err, wantErr)
+	}
+}